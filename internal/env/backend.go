@@ -0,0 +1,115 @@
+package env
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is returned by Backend.Get when name does not exist.
+var ErrNotFound = errors.New("env: entry not found")
+
+// Backend abstracts the persistence of environment files and the env-order
+// index away from the local filesystem so environments can be shared across
+// workstations and CI runners without copying plaintext files around.
+type Backend interface {
+	// List returns the names of all entries currently stored in the backend.
+	List() ([]string, error)
+	// Get returns the raw contents of the entry with the given name.
+	Get(name string) ([]byte, error)
+	// Put stores data under the given name, replacing any previous contents.
+	// Implementations must make the write appear atomic, e.g. by writing to
+	// a temporary location and renaming it into place.
+	Put(name string, data []byte) error
+	// Delete removes the entry with the given name.
+	Delete(name string) error
+}
+
+// localBackend is the default Backend implementation and stores entries as
+// plain files on the local filesystem. This is the behavior Reader has
+// always had. Entries named envOrderFileName are rooted at dataDir instead
+// of dir, so the env-order index can live under XDG_DATA_HOME while
+// environment files live under XDG_CONFIG_HOME.
+type localBackend struct {
+	dir     string
+	dataDir string
+}
+
+// newLocalBackend returns a Backend that stores every entry, including the
+// env-order index, under dir.
+func newLocalBackend(dir string) *localBackend {
+	return &localBackend{dir: dir, dataDir: dir}
+}
+
+// newLocalBackendXDG returns a Backend that stores environment files under
+// dir and the env-order index under dataDir.
+func newLocalBackendXDG(dir, dataDir string) *localBackend {
+	return &localBackend{dir: dir, dataDir: dataDir}
+}
+
+func (b *localBackend) rootFor(name string) string {
+	if name == envOrderFileName {
+		return b.dataDir
+	}
+	return b.dir
+}
+
+func (b *localBackend) List() ([]string, error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *localBackend) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.rootFor(name), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b *localBackend) Put(name string, data []byte) error {
+	root := b.rootFor(name)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, name)
+	tmp, err := ioutil.TempFile(root, ".tmp-"+name+"-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (b *localBackend) Delete(name string) error {
+	return os.Remove(filepath.Join(b.rootFor(name), name))
+}
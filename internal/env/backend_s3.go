@@ -0,0 +1,105 @@
+package env
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Backend stores environment entries as objects in an S3-compatible
+// bucket, which also covers self-hosted MinIO deployments by pointing
+// Endpoint at the MinIO server.
+type S3Backend struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string // optional, set for MinIO or other S3-compatible servers
+
+	client *s3.S3
+}
+
+// NewS3Backend returns a Backend backed by the given S3 bucket. Credentials
+// and region are resolved the usual AWS SDK way (environment, shared config,
+// instance role, ...).
+func NewS3Backend(bucket, prefix, endpoint string) (*S3Backend, error) {
+	cfg := aws.NewConfig()
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Backend{
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Endpoint: endpoint,
+		client:   s3.New(sess),
+	}, nil
+}
+
+func (b *S3Backend) key(name string) string {
+	if b.Prefix == "" {
+		return name
+	}
+	return b.Prefix + "/" + name
+}
+
+func (b *S3Backend) List() ([]string, error) {
+	out, err := b.client.ListObjects(&s3.ListObjectsInput{
+		Bucket: aws.String(b.Bucket),
+		Prefix: aws.String(b.Prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(out.Contents))
+	for _, obj := range out.Contents {
+		key := aws.StringValue(obj.Key)
+		if b.Prefix != "" {
+			key = key[len(b.Prefix)+1:]
+		}
+		names = append(names, key)
+	}
+	return names, nil
+}
+
+func (b *S3Backend) Get(name string) ([]byte, error) {
+	out, err := b.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (b *S3Backend) Put(name string, data []byte) error {
+	// S3 object writes are atomic by design: a GET will never observe a
+	// partial PUT, so no temp-object/rename dance is required here.
+	_, err := b.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (b *S3Backend) Delete(name string) error {
+	_, err := b.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	return err
+}
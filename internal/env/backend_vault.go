@@ -0,0 +1,93 @@
+package env
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// VaultBackend stores environment entries as base64-encoded blobs in a
+// Vault KV (v2) secrets engine, one secret per environment.
+type VaultBackend struct {
+	Mount string // e.g. "secret"
+	Path  string // path prefix below the mount, e.g. "rriclient/environments"
+
+	client *vault.Client
+}
+
+// NewVaultBackend returns a Backend backed by the given Vault KV mount and
+// path prefix, using client for all requests.
+func NewVaultBackend(client *vault.Client, mount, path string) *VaultBackend {
+	return &VaultBackend{Mount: mount, Path: path, client: client}
+}
+
+func (b *VaultBackend) secretPath(name string) string {
+	return path.Join(b.Mount, "data", b.Path, name)
+}
+
+func (b *VaultBackend) metadataPath() string {
+	return path.Join(b.Mount, "metadata", b.Path)
+}
+
+func (b *VaultBackend) List() ([]string, error) {
+	secret, err := b.client.Logical().List(b.metadataPath())
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return []string{}, nil
+	}
+
+	raw, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return []string{}, nil
+	}
+
+	names := make([]string, 0, len(raw))
+	for _, k := range raw {
+		if s, ok := k.(string); ok {
+			names = append(names, s)
+		}
+	}
+	return names, nil
+}
+
+func (b *VaultBackend) Get(name string) ([]byte, error) {
+	secret, err := b.client.Logical().Read(b.secretPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, ErrNotFound
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("vault: malformed secret at %q", b.secretPath(name))
+	}
+	encoded, ok := data["content"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: secret at %q has no content field", b.secretPath(name))
+	}
+
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func (b *VaultBackend) Put(name string, data []byte) error {
+	// Vault's KV v2 engine versions every write, so the previous version
+	// remains readable until a PUT fully succeeds - writes are effectively
+	// atomic from a reader's point of view.
+	_, err := b.client.Logical().Write(b.secretPath(name), map[string]interface{}{
+		"data": map[string]interface{}{
+			"content": base64.StdEncoding.EncodeToString(data),
+		},
+	})
+	return err
+}
+
+func (b *VaultBackend) Delete(name string) error {
+	_, err := b.client.Logical().Delete(b.metadataPath() + "/" + name)
+	return err
+}
@@ -0,0 +1,335 @@
+package env
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sbreitf1/go-jcrypt"
+)
+
+// ImportEnvFile reads a dotenv-formatted file at path, resolves variable
+// interpolation and stores the result as the environment envName, the same
+// way CreateOrReadEnvironment would. Values may reference ${VAR} or
+// ${VAR:-default}; references are resolved against (1) keys already defined
+// earlier in the same file, (2) the process environment, in that order -
+// whichever resolves the name first wins - and an unset reference with no
+// default resolves to the empty string.
+func (e *Reader) ImportEnvFile(envName, path string, env interface{}) error {
+	return e.importEnvFile(envName, path, env, nil)
+}
+
+// ImportEnvFileWithOverlay behaves like ImportEnvFile but additionally
+// resolves ${VAR} references against overlay, which is consulted after the
+// file itself and the process environment have both failed to resolve a
+// name.
+func (e *Reader) ImportEnvFileWithOverlay(envName, path string, env interface{}, overlay map[string]string) error {
+	return e.importEnvFile(envName, path, env, overlay)
+}
+
+func (e *Reader) importEnvFile(envName, path string, env interface{}, overlay map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values, err := parseDotenv(f, overlay)
+	if err != nil {
+		return fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, env); err != nil {
+		return err
+	}
+
+	file := e.getEnvFileName(envName)
+	keyOpts := e.jcryptOptions(envName)
+	if _, err := e.backend.Get(file); isNotExist(err) {
+		if keyOpts, err = e.newEnvironmentKeyOptions(envName); err != nil {
+			return err
+		}
+	}
+	out, err := jcrypt.Marshal(env, keyOpts)
+	if err != nil {
+		return err
+	}
+	if out, err = wrapWithSchema(out, e.schemaFor(env)); err != nil {
+		return err
+	}
+	if err := e.backend.Put(file, out); err != nil {
+		return err
+	}
+	return e.envOrderBringToFront(envName)
+}
+
+// ExportEnvFile writes the environment envName as a dotenv file to path.
+// env is populated the same way ReadEnvironment would and only used to
+// discover which fields are tagged for jcrypt encryption; those fields are
+// replaced with a ${KEY} reference instead of their value unless
+// includeSecrets is true, so the file defaults to being safe to commit to
+// version control.
+func (e *Reader) ExportEnvFile(envName, path string, env interface{}, includeSecrets ...bool) error {
+	if err := e.ReadEnvironment(envName, env); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	secretFields := secretFieldNames(env)
+	include := len(includeSecrets) > 0 && includeSecrets[0]
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		if secretFields[k] && !include {
+			fmt.Fprintf(&b, "%s=${%s}\n", k, strings.ToUpper(k))
+			continue
+		}
+		fmt.Fprintf(&b, "%s=%s\n", k, quoteDotenvValue(fmt.Sprintf("%v", raw[k])))
+	}
+
+	return ioutil.WriteFile(path, []byte(b.String()), os.ModePerm)
+}
+
+// secretFieldNames returns the set of JSON field names of env's underlying
+// struct that are tagged for jcrypt encryption.
+func secretFieldNames(env interface{}) map[string]bool {
+	secrets := make(map[string]bool)
+
+	v := reflect.ValueOf(env)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return secrets
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("jcrypt"); ok {
+			secrets[jsonFieldName(field)] = true
+		}
+	}
+	return secrets
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func quoteDotenvValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t#\"'$") {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v)
+		return `"` + escaped + `"`
+	}
+	return v
+}
+
+// parseDotenv reads dotenv-formatted content line by line, handling
+// `export KEY=...` prefixes, `# comments`, double-quoted values with escape
+// sequences, single-quoted literal values, and ${VAR}/${VAR:-default}
+// interpolation. Earlier definitions within the file win over later ones
+// that reference the same name; the process environment is only consulted
+// for names not yet defined in the file, and overlay is consulted last.
+func parseDotenv(f *os.File, overlay map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		key := strings.TrimSpace(line[:eq])
+		rawValue := strings.TrimSpace(line[eq+1:])
+
+		value, err := parseDotenvValue(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		if _, ok := values[key]; ok {
+			continue
+		}
+		literal := len(rawValue) >= 2 && rawValue[0] == '\'' && rawValue[len(rawValue)-1] == '\''
+		if !literal {
+			value = resolveDotenvRefs(value, values, overlay)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// parseDotenvValue strips quoting from a raw value and unescapes
+// double-quoted content. Single-quoted values are returned literally.
+func parseDotenvValue(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		inner := raw[1 : len(raw)-1]
+		var b strings.Builder
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) {
+				i++
+				switch inner[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$':
+					b.WriteByte(inner[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(inner[i])
+				}
+				continue
+			}
+			b.WriteByte(inner[i])
+		}
+		return b.String(), nil
+	}
+
+	if idx := strings.Index(raw, " #"); idx >= 0 {
+		raw = strings.TrimSpace(raw[:idx])
+	}
+	return raw, nil
+}
+
+// resolveDotenvRefs substitutes every ${VAR} / ${VAR:-default} reference in
+// value, preferring names already defined in the file, then the process
+// environment, then overlay. It scans braces manually rather than with a
+// regexp so that a default itself containing ${...} - e.g.
+// ${VAR:-${OTHER}} - resolves against its matching closing brace instead of
+// the first "}" anywhere in the default.
+func resolveDotenvRefs(value string, defined map[string]string, overlay map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		if value[i] == '$' && i+1 < len(value) && value[i+1] == '{' {
+			if end, name, def, hasDefault, ok := scanDotenvRef(value, i); ok {
+				b.WriteString(resolveDotenvVar(name, def, hasDefault, defined, overlay))
+				i = end
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+		i++
+	}
+	return b.String()
+}
+
+func resolveDotenvVar(name, def string, hasDefault bool, defined map[string]string, overlay map[string]string) string {
+	if v, ok := defined[name]; ok {
+		return v
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	if v, ok := overlay[name]; ok {
+		return v
+	}
+	if hasDefault {
+		return resolveDotenvRefs(def, defined, overlay)
+	}
+	return ""
+}
+
+// scanDotenvRef parses a single ${VAR} or ${VAR:-default} reference starting
+// at value[start] (which must be '$'), tracking brace depth so a nested
+// ${...} inside default does not close the reference early. It returns the
+// index just past the matching closing brace, the variable name, the
+// default expression (unresolved), whether a default was present, and
+// whether a well-formed reference was found at all.
+func scanDotenvRef(value string, start int) (end int, name, def string, hasDefault, ok bool) {
+	depth := 1
+	i := start + 2
+	for i < len(value) {
+		switch {
+		case value[i] == '{' && value[i-1] == '$':
+			depth++
+		case value[i] == '}':
+			depth--
+			if depth == 0 {
+				inner := value[start+2 : i]
+				name, def, hasDefault = splitDotenvDefault(inner)
+				if !isValidDotenvVarName(name) {
+					return 0, "", "", false, false
+				}
+				return i + 1, name, def, hasDefault, true
+			}
+		}
+		i++
+	}
+	return 0, "", "", false, false
+}
+
+// splitDotenvDefault splits inner (the content of "${...}") into its
+// variable name and default expression at the first ":-", if any. A
+// variable name can never itself contain ":-", so the first occurrence
+// always belongs to the separator, even when the default goes on to
+// contain further ${...} references.
+func splitDotenvDefault(inner string) (name, def string, hasDefault bool) {
+	if idx := strings.Index(inner, ":-"); idx >= 0 {
+		return inner[:idx], inner[idx+2:], true
+	}
+	return inner, "", false
+}
+
+func isValidDotenvVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package env
+
+import "testing"
+
+// TestResolveDotenvRefsNestedDefault guards against the regression the
+// brace-aware scanner in scanDotenvRef replaced a plain regexp for: a
+// default value that itself contains a ${...} reference must resolve
+// against its own matching closing brace, not the first "}" found anywhere
+// in the outer reference.
+func TestResolveDotenvRefsNestedDefault(t *testing.T) {
+	defined := map[string]string{"OTHER": "fallback", "DEFINEDVAR": "direct"}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "nested default resolves inner ref",
+			value: "${VAR:-${OTHER}}",
+			want:  "fallback",
+		},
+		{
+			name:  "outer var already defined wins over nested default",
+			value: "${DEFINEDVAR:-${OTHER}}",
+			want:  "direct",
+		},
+		{
+			name:  "doubly nested default",
+			value: "${VAR:-${OTHER:-${MISSING}}}",
+			want:  "fallback",
+		},
+		{
+			name:  "no default and unset resolves to empty string",
+			value: "${MISSING}",
+			want:  "",
+		},
+		{
+			name:  "plain default with no nesting",
+			value: "${MISSING:-plain}",
+			want:  "plain",
+		},
+		{
+			name:  "literal text around a reference is preserved",
+			value: "prefix-${OTHER}-suffix",
+			want:  "prefix-fallback-suffix",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := resolveDotenvRefs(tc.value, defined, nil)
+			if got != tc.want {
+				t.Errorf("resolveDotenvRefs(%q) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestScanDotenvRefMismatchedBraces verifies that a reference with no
+// matching closing brace is rejected rather than matched against a brace
+// belonging to an unrelated, later reference.
+func TestScanDotenvRefMismatchedBraces(t *testing.T) {
+	_, _, _, _, ok := scanDotenvRef("${VAR", 0)
+	if ok {
+		t.Fatalf("scanDotenvRef on an unterminated reference returned ok=true")
+	}
+}
+
+// TestResolveDotenvVarPrecedence verifies the documented resolution order:
+// values already defined in the file win over the process environment,
+// which wins over the overlay, which wins over the default expression.
+func TestResolveDotenvVarPrecedence(t *testing.T) {
+	t.Setenv("RRICLIENT_DOTENV_TEST_VAR", "from-environ")
+
+	defined := map[string]string{"RRICLIENT_DOTENV_TEST_VAR": "from-file"}
+	overlay := map[string]string{"RRICLIENT_DOTENV_TEST_VAR": "from-overlay", "RRICLIENT_DOTENV_TEST_VAR_UNSET": "from-overlay"}
+
+	got := resolveDotenvVar("RRICLIENT_DOTENV_TEST_VAR", "from-default", true, defined, overlay)
+	if got != "from-file" {
+		t.Fatalf("resolveDotenvVar = %q, want %q (file should win)", got, "from-file")
+	}
+
+	got = resolveDotenvVar("RRICLIENT_DOTENV_TEST_VAR", "from-default", true, nil, overlay)
+	if got != "from-environ" {
+		t.Fatalf("resolveDotenvVar = %q, want %q (process environ should win over overlay)", got, "from-environ")
+	}
+
+	got = resolveDotenvVar("RRICLIENT_DOTENV_TEST_VAR_UNSET", "from-default", true, nil, overlay)
+	if got != "from-overlay" {
+		t.Fatalf("resolveDotenvVar = %q, want %q (overlay should win over default)", got, "from-overlay")
+	}
+}
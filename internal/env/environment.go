@@ -3,13 +3,13 @@ package env
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/manifoldco/promptui"
 	"github.com/sbreitf1/go-console"
@@ -21,8 +21,21 @@ const (
 )
 
 type envOrder struct {
-	Fixed bool     `json:"fixed"`
-	Order []string `json:"order"`
+	Fixed bool                `json:"fixed"`
+	Order []string            `json:"order"`
+	Meta  map[string]*envMeta `json:"meta,omitempty"`
+}
+
+func (o *envOrder) metaFor(name string) *envMeta {
+	if o.Meta == nil {
+		o.Meta = make(map[string]*envMeta)
+	}
+	m, ok := o.Meta[name]
+	if !ok {
+		m = &envMeta{}
+		o.Meta[name] = m
+	}
+	return m
 }
 
 // GetKeyHandler returns the encryption key for encryption or decryption.
@@ -37,29 +50,47 @@ type GetEnvFileTitleHandler func(envName, envFile string) string
 // Reader represents a reader object for environments.
 type Reader struct {
 	dir             string
+	backend         Backend
+	keyProvider     KeyProvider
+	schemas         map[string]*EnvSchema
+	locking         bool
 	KeySource       GetKeyHandler
 	EnterEnvHandler EnterEnvHandler
 	GetEnvFileTitle GetEnvFileTitleHandler
 }
 
-// Dir returns the configuration directory.
-func (e *Reader) Dir() string {
-	return e.dir
+// ReaderOption configures a Reader returned by NewReader.
+type ReaderOption func(*Reader)
+
+// WithBackend makes the Reader persist environments and the env-order index
+// through backend instead of the local filesystem.
+func WithBackend(backend Backend) ReaderOption {
+	return func(e *Reader) { e.backend = backend }
 }
 
-// NewReader returns a new environment reader using the given key source and enter environment handler.
-func NewReader(homeDirName string) (*Reader, error) {
-	dir, err := getConfigDir()
-	if err != nil {
-		return nil, err
-	}
+// WithKeyProvider makes the Reader request the jcrypt encryption key for
+// each environment from keyProvider instead of the legacy KeySource field,
+// so the key itself never has to live on disk.
+func WithKeyProvider(keyProvider KeyProvider) ReaderOption {
+	return func(e *Reader) { e.keyProvider = keyProvider }
+}
 
-	return &Reader{dir: filepath.Join(dir, homeDirName)}, nil
+// WithLocking controls whether the Reader takes an OS-level advisory lock
+// around every read-modify-write cycle of an environment file or the
+// env-order index, guarding against two rriclient processes racing each
+// other. It defaults to on.
+func WithLocking(enabled bool) ReaderOption {
+	return func(e *Reader) { e.locking = enabled }
+}
+
+// Dir returns the configuration directory.
+func (e *Reader) Dir() string {
+	return e.dir
 }
 
-func (e *Reader) getEnvFilePath(envName string) string {
+func (e *Reader) getEnvFileName(envName string) string {
 	//TODO conditional escaping
-	return filepath.Join(e.dir, envName+".json")
+	return envName + ".json"
 }
 
 // ReadEnvironment reads an existing environment.
@@ -73,33 +104,41 @@ func (e *Reader) CreateOrReadEnvironment(envName string, env interface{}) error
 }
 
 func (e *Reader) createOrReadEnvironment(envName string, env interface{}, enterEnvHandler EnterEnvHandler) error {
-	file := e.getEnvFilePath(envName)
-	exists, err := isFile(file)
-	if err != nil {
-		return err
-	}
+	file := e.getEnvFileName(envName)
+	return e.withLock(file, func() error {
+		return e.createOrReadEnvironmentLocked(envName, env, enterEnvHandler)
+	})
+}
 
-	keySource := jcrypt.KeySource(e.KeySource)
-	if keySource == nil {
-		keySource = func() ([]byte, error) { return []byte{}, nil }
+func (e *Reader) createOrReadEnvironmentLocked(envName string, env interface{}, enterEnvHandler EnterEnvHandler) error {
+	file := e.getEnvFileName(envName)
+	data, err := e.backend.Get(file)
+	exists := err == nil
+	if err != nil && !isNotExist(err) {
+		return err
 	}
 
 	if !exists {
 		if enterEnvHandler != nil {
 			console.Printlnf("Environment %q does not exist yet, pleaser enter below:", envName)
-			err := enterEnvHandler(envName, env)
-			if err != nil {
+			if err := enterEnvHandler(envName, env); err != nil {
 				return err
 			}
 
-			if err := os.MkdirAll(e.dir, os.ModePerm); err != nil {
+			keyOpts, err := e.newEnvironmentKeyOptions(envName)
+			if err != nil {
+				console.Printlnf("WARNING: failed to save environment: %s", err.Error())
+				e.envOrderBringToFront(envName)
+				return nil
+			}
+
+			out, err := jcrypt.Marshal(env, keyOpts)
+			if err != nil {
+				console.Printlnf("WARNING: failed to save environment: %s", err.Error())
+			} else if out, err = wrapWithSchema(out, e.schemaFor(env)); err != nil {
+				console.Printlnf("WARNING: failed to save environment: %s", err.Error())
+			} else if err := e.backend.Put(file, out); err != nil {
 				console.Printlnf("WARNING: failed to save environment: %s", err.Error())
-			} else {
-				if err := jcrypt.MarshalToFile(file, env, &jcrypt.Options{
-					GetKeyHandler: keySource,
-				}); err != nil {
-					console.Printlnf("WARNING: failed to save environment: %s", err.Error())
-				}
 			}
 
 			e.envOrderBringToFront(envName)
@@ -108,18 +147,51 @@ func (e *Reader) createOrReadEnvironment(envName string, env interface{}, enterE
 		return fmt.Errorf("environment %q not found", envName)
 	}
 
-	if err := jcrypt.UnmarshalFromFile(file, env, &jcrypt.Options{
-		GetKeyHandler: keySource,
-	}); err != nil {
+	if schema := e.schemaFor(env); schema != nil {
+		header, ok := peekSchemaHeader(data)
+		if !ok {
+			// File predates RegisterSchema entirely, so it carries no
+			// _schema header at all. Treat it as the earliest registered
+			// version rather than skipping migration, otherwise fields
+			// added by later versions stay silently zero-valued forever
+			// and the file is never re-tagged so a later read can catch it.
+			header = schemaHeader{Kind: schema.kind, Version: schema.earliest().version}
+		}
+		if header.Kind != schema.kind {
+			return &SchemaError{EnvName: envName, Kind: header.Kind, StoredVersion: header.Version, WantVersion: schema.latest().version,
+				Reason: fmt.Sprintf("expected kind %q", schema.kind)}
+		}
+		if header.Version < schema.latest().version {
+			migrated, err := migrateEnvironment(envName, data, env, schema, header, e.jcryptOptions(envName))
+			if err != nil {
+				return err
+			}
+			if err := e.backend.Put(file, migrated); err != nil {
+				return err
+			}
+			e.envOrderBringToFront(envName)
+			return nil
+		}
+	}
+
+	if err := jcrypt.Unmarshal(data, env, e.jcryptOptions(envName)); err != nil {
 		return err
 	}
 	e.envOrderBringToFront(envName)
 	return nil
 }
 
-// SelectEnvironment displays all configured environments in specified order and prompts the user.
+// SelectEnvironment displays all configured environments - grouped, tagged
+// and pinned as configured via SetGroup/TagEnvironment/PinEnvironment - and
+// prompts the user. If more than one group is in use, this is a two-step
+// picker: a first prompt to pick the group (ungrouped environments count as
+// their own group), then a second to pick the environment within it. With
+// zero or one group in use, the group step is skipped and the environment
+// itself is picked directly, since a group pane over a single group buys
+// nothing. Typing "/" in the environment step fuzzy-filters the list by
+// title, file name and tags.
 func (e *Reader) SelectEnvironment(env interface{}) error {
-	envFiles, err := e.GetEnvironmentFiles()
+	envFiles, err := e.envFileNames()
 	if err != nil {
 		return err
 	}
@@ -127,32 +199,145 @@ func (e *Reader) SelectEnvironment(env interface{}) error {
 		return fmt.Errorf("no environments specified")
 	}
 
+	order, _ := e.readEnvOrder()
+
+	groups := distinctEnvGroups(envFiles, order)
+	if len(groups) > 1 {
+		group, err := selectEnvGroup(groups)
+		if err != nil {
+			return err
+		}
+
+		filtered := make([]string, 0, len(envFiles))
+		for _, name := range envFiles {
+			if envGroup(order, name) == group {
+				filtered = append(filtered, name)
+			}
+		}
+		envFiles = filtered
+	}
+
+	return e.selectEnvironmentFile(envFiles, order, env)
+}
+
+// distinctEnvGroups returns the distinct groups assigned to envFiles via
+// SetGroup, sorted alphabetically with the ungrouped "" group (if present)
+// last.
+func distinctEnvGroups(envFiles []string, order envOrder) []string {
+	seen := make(map[string]bool)
+	for _, name := range envFiles {
+		seen[envGroup(order, name)] = true
+	}
+
+	groups := make([]string, 0, len(seen))
+	for group := range seen {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i] == "" {
+			return false
+		}
+		if groups[j] == "" {
+			return true
+		}
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+func envGroup(order envOrder, name string) string {
+	if m, ok := order.Meta[name]; ok && m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+// selectEnvGroup prompts the user to pick one of groups, the first pane of
+// SelectEnvironment's two-pane picker. The ungrouped "" group is displayed
+// as "(ungrouped)".
+func selectEnvGroup(groups []string) (string, error) {
+	labels := make([]string, len(groups))
+	for i, group := range groups {
+		if group == "" {
+			labels[i] = "(ungrouped)"
+		} else {
+			labels[i] = group
+		}
+	}
+
+	ui := promptui.Select{Label: "Select group", Items: labels, HideSelected: true}
+	index, _, err := ui.Run()
+	if err != nil {
+		return "", err
+	}
+	return groups[index], nil
+}
+
+// selectEnvironmentFile prompts the user to pick one of envFiles, the
+// second pane of SelectEnvironment's two-pane picker.
+func (e *Reader) selectEnvironmentFile(envFiles []string, order envOrder, env interface{}) error {
 	envTitles := make([]string, len(envFiles))
-	for i, fi := range envFiles {
-		name := fi.Name()
-		if strings.HasSuffix(name, ".json") {
-			name = name[:len(name)-5]
+	searchText := make([]string, len(envFiles))
+	for i, name := range envFiles {
+		title := name
+		if strings.HasSuffix(title, ".json") {
+			title = title[:len(title)-5]
 		}
 
 		if e.GetEnvFileTitle != nil {
-			envTitles[i] = e.GetEnvFileTitle(name, filepath.Join(e.dir, fi.Name()))
+			envTitles[i] = e.GetEnvFileTitle(title, name)
 		} else {
-			envTitles[i] = name
+			envTitles[i] = title
+		}
+
+		meta := envMeta{}
+		if m, ok := order.Meta[name]; ok && m != nil {
+			meta = *m
+		}
+		if len(meta.Tags) > 0 {
+			envTitles[i] = fmt.Sprintf("%s (#%s)", envTitles[i], strings.Join(meta.Tags, " #"))
 		}
+		if meta.Pinned {
+			envTitles[i] = "* " + envTitles[i]
+		}
+		if e.isSchemaOutdated(name) {
+			envTitles[i] += " (outdated schema)"
+		}
+
+		searchText[i] = envSearchText(title, name, meta)
+	}
+
+	ui := promptui.Select{
+		Label:        "Select environment",
+		Items:        envTitles,
+		HideSelected: true,
+		Searcher: func(input string, index int) bool {
+			text := []rune(searchText[index])
+			j := 0
+			for _, r := range strings.ToLower(input) {
+				for j < len(text) && text[j] != r {
+					j++
+				}
+				if j == len(text) {
+					return false
+				}
+				j++
+			}
+			return true
+		},
 	}
-	ui := promptui.Select{Label: "Select environment", Items: envTitles, HideSelected: true}
 	index, _, err := ui.Run()
 	if err != nil {
 		return err
 	}
 
-	fileName := envFiles[index].Name()
+	fileName := envFiles[index]
 	return e.createOrReadEnvironment(fileName[:len(fileName)-5], env, nil)
 }
 
 // ListEnvironments returns a list of all environment titles.
 func (e *Reader) ListEnvironments() ([]string, error) {
-	envFiles, err := e.GetEnvironmentFiles()
+	envFiles, err := e.envFileNames()
 	if err != nil {
 		return nil, err
 	}
@@ -161,47 +346,70 @@ func (e *Reader) ListEnvironments() ([]string, error) {
 	}
 
 	envTitles := make([]string, len(envFiles))
-	for i, fi := range envFiles {
-		name := fi.Name()
-		if strings.HasSuffix(name, ".json") {
-			name = name[:len(name)-5]
+	for i, name := range envFiles {
+		title := name
+		if strings.HasSuffix(title, ".json") {
+			title = title[:len(title)-5]
 		}
 
 		if e.GetEnvFileTitle != nil {
-			envTitles[i] = e.GetEnvFileTitle(name, filepath.Join(e.dir, fi.Name()))
+			envTitles[i] = e.GetEnvFileTitle(title, name)
 		} else {
-			envTitles[i] = name
+			envTitles[i] = title
 		}
 	}
 	return envTitles, nil
 }
 
-// GetEnvironmentFiles returns an ordered list of files that contain environments.
+// GetEnvironmentFiles returns an ordered list of files that contain
+// environments. For backends other than the local filesystem - S3, Vault,
+// etc. - the returned os.FileInfo values are synthesized from whatever
+// metadata the backend can provide and should not be relied on for anything
+// beyond Name(); callers that only need names should prefer
+// GetEnvironmentNames instead.
 func (e *Reader) GetEnvironmentFiles() ([]os.FileInfo, error) {
-	files, err := ioutil.ReadDir(e.dir)
+	names, err := e.envFileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	envFiles := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		envFiles = append(envFiles, e.fileInfoFor(name))
+	}
+	return envFiles, nil
+}
+
+// GetEnvironmentNames returns an ordered list of names of entries that
+// contain environments, the same order GetEnvironmentFiles returns them in.
+func (e *Reader) GetEnvironmentNames() ([]string, error) {
+	return e.envFileNames()
+}
+
+// envFileNames returns an ordered list of names of entries that contain
+// environments.
+func (e *Reader) envFileNames() ([]string, error) {
+	names, err := e.backend.List()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []os.FileInfo{}, nil
-		}
 		return nil, err
 	}
 
-	envFiles := make([]os.FileInfo, 0)
-	for _, fi := range files {
-		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".json") && fi.Name() != envOrderFileName {
-			envFiles = append(envFiles, fi)
+	envFiles := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, ".json") && name != envOrderFileName {
+			envFiles = append(envFiles, name)
 		}
 	}
 
 	order, _ := e.readEnvOrder()
-	if order.Order != nil && len(order.Order) > 0 {
+	if order.Fixed && len(order.Order) > 0 {
 		orderMap := make(map[string]int)
 		for i, name := range order.Order {
 			orderMap[name] = i
 		}
 		sort.SliceStable(envFiles, func(i, j int) bool {
-			iVal, iOk := orderMap[envFiles[i].Name()]
-			jVal, jOk := orderMap[envFiles[j].Name()]
+			iVal, iOk := orderMap[envFiles[i]]
+			jVal, jOk := orderMap[envFiles[j]]
 			if !iOk {
 				iVal = math.MaxInt32
 			}
@@ -210,20 +418,50 @@ func (e *Reader) GetEnvironmentFiles() ([]os.FileInfo, error) {
 			}
 			return iVal < jVal
 		})
+	} else {
+		sortByPinnedGroupRecency(envFiles, order)
 	}
 
 	return envFiles, nil
 }
 
+// fakeFileInfo is a minimal os.FileInfo for backends that have no real
+// filesystem entry to stat, e.g. S3 or Vault.
+type fakeFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return 0 }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// fileInfoFor returns an os.FileInfo for the environment file name. The
+// local backend stats the real file on disk; any other backend gets a
+// fakeFileInfo with only Name() and Size() populated.
+func (e *Reader) fileInfoFor(name string) os.FileInfo {
+	if lb, ok := e.backend.(*localBackend); ok {
+		if fi, err := os.Stat(filepath.Join(lb.rootFor(name), name)); err == nil {
+			return fi
+		}
+	}
+
+	data, _ := e.backend.Get(name)
+	return fakeFileInfo{name: name, size: int64(len(data))}
+}
+
 func (e *Reader) readEnvOrder() (envOrder, error) {
-	orderData, err := ioutil.ReadFile(filepath.Join(e.dir, envOrderFileName))
+	orderData, err := e.backend.Get(envOrderFileName)
 	if err != nil {
-		return envOrder{false, []string{}}, nil
+		return envOrder{Fixed: false, Order: []string{}}, nil
 	}
 
 	var order envOrder
 	if err := json.Unmarshal(orderData, &order); err != nil {
-		return envOrder{false, []string{}}, nil
+		return envOrder{Fixed: false, Order: []string{}}, nil
 	}
 
 	return order, nil
@@ -235,52 +473,58 @@ func (e *Reader) writeEnvOrder(order envOrder) error {
 		return err
 	}
 
-	return ioutil.WriteFile(filepath.Join(e.dir, envOrderFileName), orderData, os.ModePerm)
+	return e.backend.Put(envOrderFileName, orderData)
 }
 
 func (e *Reader) envOrderBringToFront(name string) error {
-	order, err := e.readEnvOrder()
-	if err != nil {
-		return err
-	}
+	return e.withLock(envOrderFileName, func() error {
+		order, err := e.readEnvOrder()
+		if err != nil {
+			return err
+		}
 
-	if order.Fixed {
-		return nil
-	}
+		if order.Fixed {
+			return nil
+		}
 
-	if !strings.HasSuffix(name, ".json") {
-		name += ".json"
-	}
-	if order.Order == nil {
-		order.Order = []string{name}
-	} else {
-		newOrder := []string{name}
-		for _, env := range order.Order {
-			if env != name {
-				newOrder = append(newOrder, env)
+		if !strings.HasSuffix(name, ".json") {
+			name += ".json"
+		}
+		if order.Order == nil {
+			order.Order = []string{name}
+		} else {
+			newOrder := []string{name}
+			for _, env := range order.Order {
+				if env != name {
+					newOrder = append(newOrder, env)
+				}
 			}
+			order.Order = newOrder
 		}
-		order.Order = newOrder
-	}
+		order.metaFor(name).LastUsed = time.Now()
 
-	return e.writeEnvOrder(order)
+		return e.writeEnvOrder(order)
+	})
 }
 
 // DeleteEnvironment deletes an existing environment.
 func (e *Reader) DeleteEnvironment(envName string) error {
-	file := e.getEnvFilePath(envName)
-	exists, err := isFile(file)
-	if err != nil {
-		return err
-	}
-
-	if !exists {
-		return fmt.Errorf("environment %q does not exist", envName)
-	}
+	file := e.getEnvFileName(envName)
+	return e.withLock(file, func() error {
+		if _, err := e.backend.Get(file); err != nil {
+			if isNotExist(err) {
+				return fmt.Errorf("environment %q does not exist", envName)
+			}
+			return err
+		}
 
-	return os.Remove(file)
+		return e.backend.Delete(file)
+	})
 }
 
+// getConfigDir returns the current user's home directory. It is the
+// fallback for xdgConfigDir/xdgDataDir when no XDG variable is set, and is
+// also used to locate the pre-XDG ~/homeDirName directory for migration.
 func getConfigDir() (string, error) {
 	usr, err := user.Current()
 	if err != nil {
@@ -289,13 +533,6 @@ func getConfigDir() (string, error) {
 	return usr.HomeDir, nil
 }
 
-func isFile(file string) (bool, error) {
-	fi, err := os.Stat(file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
-		return false, err
-	}
-	return !fi.IsDir(), nil
+func isNotExist(err error) bool {
+	return err == ErrNotFound
 }
@@ -0,0 +1,59 @@
+package env
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestEnvOrderBringToFrontConcurrent guards against the lock-before-mkdir
+// regression fixed in lock.go: on a brand new directory, N goroutines racing
+// to bump their own environment to the front of env-order must neither lose
+// nor duplicate entries.
+func TestEnvOrderBringToFrontConcurrent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "envs")
+	e, err := NewReaderFromDir(dir)
+	if err != nil {
+		t.Fatalf("NewReaderFromDir: %v", err)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			name := envNameForIndex(i)
+			if err := e.envOrderBringToFront(name); err != nil {
+				t.Errorf("envOrderBringToFront(%q): %v", name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	order, err := e.readEnvOrder()
+	if err != nil {
+		t.Fatalf("readEnvOrder: %v", err)
+	}
+
+	seen := make(map[string]int)
+	for _, name := range order.Order {
+		seen[name]++
+	}
+	for i := 0; i < n; i++ {
+		name := envNameForIndex(i) + ".json"
+		if seen[name] != 1 {
+			t.Errorf("entry %q appears %d times in order, want 1", name, seen[name])
+		}
+		if order.metaFor(name).LastUsed.IsZero() {
+			t.Errorf("entry %q has no LastUsed recorded", name)
+		}
+	}
+	if len(order.Order) != n {
+		t.Errorf("order has %d entries, want %d", len(order.Order), n)
+	}
+}
+
+func envNameForIndex(i int) string {
+	return "env" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+}
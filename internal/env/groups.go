@@ -0,0 +1,126 @@
+package env
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// envMeta holds the per-environment metadata tracked in the env-order file
+// alongside the plain MRU list, so SelectEnvironment can group, tag and
+// fuzzy-filter environments instead of only offering a flat recency list.
+type envMeta struct {
+	Tags     []string  `json:"tags,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	LastUsed time.Time `json:"lastUsed,omitempty"`
+	Pinned   bool      `json:"pinned,omitempty"`
+}
+
+// TagEnvironment replaces the tags associated with envName. Pass no tags to
+// clear them.
+func (e *Reader) TagEnvironment(envName string, tags ...string) error {
+	return e.withLock(envOrderFileName, func() error {
+		order, err := e.readEnvOrder()
+		if err != nil {
+			return err
+		}
+		order.metaFor(e.getEnvFileName(envName)).Tags = tags
+		return e.writeEnvOrder(order)
+	})
+}
+
+// SetGroup assigns envName to group. An empty group removes it from any
+// group it was in.
+func (e *Reader) SetGroup(envName, group string) error {
+	return e.withLock(envOrderFileName, func() error {
+		order, err := e.readEnvOrder()
+		if err != nil {
+			return err
+		}
+		order.metaFor(e.getEnvFileName(envName)).Group = group
+		return e.writeEnvOrder(order)
+	})
+}
+
+// PinEnvironment pins or unpins envName, so pinned environments sort first
+// in SelectEnvironment and ListEnvironments whenever the env-order is not
+// Fixed.
+func (e *Reader) PinEnvironment(envName string, pinned bool) error {
+	return e.withLock(envOrderFileName, func() error {
+		order, err := e.readEnvOrder()
+		if err != nil {
+			return err
+		}
+		order.metaFor(e.getEnvFileName(envName)).Pinned = pinned
+		return e.writeEnvOrder(order)
+	})
+}
+
+// RecentEnvironments returns up to n environment names (without the .json
+// suffix), most recently used first.
+func (e *Reader) RecentEnvironments(n int) []string {
+	order, err := e.readEnvOrder()
+	if err != nil || len(order.Meta) == 0 {
+		return []string{}
+	}
+
+	names := make([]string, 0, len(order.Meta))
+	for name, meta := range order.Meta {
+		if meta.LastUsed.IsZero() {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return order.Meta[names[i]].LastUsed.After(order.Meta[names[j]].LastUsed)
+	})
+
+	if n >= 0 && n < len(names) {
+		names = names[:n]
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".json")
+	}
+	return names
+}
+
+// sortByPinnedGroupRecency orders files first by pinned status, then groups
+// them together (alphabetically by group name, ungrouped entries last),
+// then by recency within a group, and finally alphabetically as a
+// tiebreaker. It is the default sort whenever the env-order is not Fixed.
+func sortByPinnedGroupRecency(files []string, order envOrder) {
+	meta := func(name string) envMeta {
+		if m, ok := order.Meta[name]; ok && m != nil {
+			return *m
+		}
+		return envMeta{}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		mi, mj := meta(files[i]), meta(files[j])
+
+		if mi.Pinned != mj.Pinned {
+			return mi.Pinned
+		}
+		if mi.Group != mj.Group {
+			if mi.Group == "" {
+				return false
+			}
+			if mj.Group == "" {
+				return true
+			}
+			return mi.Group < mj.Group
+		}
+		if !mi.LastUsed.Equal(mj.LastUsed) {
+			return mi.LastUsed.After(mj.LastUsed)
+		}
+		return files[i] < files[j]
+	})
+}
+
+// envSearchText returns the text fuzzy-searched against for name: its
+// title, the file name and its tags, space-joined and lowercased.
+func envSearchText(title, name string, meta envMeta) string {
+	parts := append([]string{title, name}, meta.Tags...)
+	return strings.ToLower(strings.Join(parts, " "))
+}
@@ -0,0 +1,73 @@
+package env
+
+import "github.com/sbreitf1/go-jcrypt"
+
+// KeyProvider supplies the symmetric key jcrypt uses to encrypt and decrypt
+// an environment file. Unlike GetKeyHandler, a KeyProvider is told which
+// environment it is being asked for, so implementations can keep the key
+// material entirely off disk, e.g. by requesting it from a cloud KMS or
+// Vault's Transit engine.
+type KeyProvider interface {
+	Key(envName string) ([]byte, error)
+}
+
+// KeyGenerator is implemented by KeyProviders that can mint a brand new data
+// key for an environment that does not exist yet, instead of only decrypting
+// a pre-existing ciphertext. createOrReadEnvironment calls GenerateKey the
+// first time an environment is created; a provider implementing it is
+// expected to persist whatever ciphertext it mints through its own
+// ciphertext storage, the same way its Ciphertext field retrieves it back
+// for Key.
+type KeyGenerator interface {
+	GenerateKey(envName string) ([]byte, error)
+}
+
+// KeyProviderFunc adapts a function to a KeyProvider.
+type KeyProviderFunc func(envName string) ([]byte, error)
+
+// Key implements KeyProvider.
+func (f KeyProviderFunc) Key(envName string) ([]byte, error) {
+	return f(envName)
+}
+
+// staticKeyProvider wraps the legacy GetKeyHandler field of Reader, which
+// does not know the environment name, so existing callers keep working
+// unchanged.
+type staticKeyProvider struct {
+	source GetKeyHandler
+}
+
+func (p staticKeyProvider) Key(envName string) ([]byte, error) {
+	if p.source == nil {
+		return []byte{}, nil
+	}
+	return p.source()
+}
+
+func (e *Reader) jcryptOptions(envName string) *jcrypt.Options {
+	kp := e.keyProvider
+	if kp == nil {
+		kp = staticKeyProvider{source: e.KeySource}
+	}
+	return &jcrypt.Options{
+		GetKeyHandler: func() ([]byte, error) { return kp.Key(envName) },
+	}
+}
+
+// newEnvironmentKeyOptions returns the jcrypt options to encrypt a brand new
+// environment with. If the configured KeyProvider implements KeyGenerator,
+// it mints a fresh data key for envName instead of going through the usual
+// Key/Ciphertext round trip, which would otherwise fail since no ciphertext
+// exists yet for an environment that has never been written before.
+func (e *Reader) newEnvironmentKeyOptions(envName string) (*jcrypt.Options, error) {
+	if gen, ok := e.keyProvider.(KeyGenerator); ok {
+		plaintext, err := gen.GenerateKey(envName)
+		if err != nil {
+			return nil, err
+		}
+		return &jcrypt.Options{
+			GetKeyHandler: func() ([]byte, error) { return plaintext, nil },
+		}, nil
+	}
+	return e.jcryptOptions(envName), nil
+}
@@ -0,0 +1,200 @@
+package env
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	vault "github.com/hashicorp/vault/api"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// AWSKMSKeyProvider derives the per-environment data key by asking AWS KMS
+// to decrypt a ciphertext blob stored alongside the environment, so the
+// plaintext key is never written to disk.
+type AWSKMSKeyProvider struct {
+	KeyID           string
+	Ciphertext      func(envName string) ([]byte, error)
+	StoreCiphertext func(envName string, ciphertext []byte) error
+
+	client *kms.KMS
+}
+
+// NewAWSKMSKeyProvider returns a KeyProvider backed by the given KMS key.
+// ciphertext must return the encrypted data key for an environment, e.g.
+// read from a sidecar file managed by the Backend. storeCiphertext is
+// called by GenerateKey to persist the ciphertext minted for a brand new
+// environment to that same sidecar, and may be nil if the provider is only
+// ever used against environments that already exist.
+func NewAWSKMSKeyProvider(keyID string, ciphertext func(envName string) ([]byte, error), storeCiphertext func(envName string, ciphertext []byte) error) (*AWSKMSKeyProvider, error) {
+	sess, err := session.NewSession(aws.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &AWSKMSKeyProvider{KeyID: keyID, Ciphertext: ciphertext, StoreCiphertext: storeCiphertext, client: kms.New(sess)}, nil
+}
+
+// Key implements KeyProvider.
+func (p *AWSKMSKeyProvider) Key(envName string) ([]byte, error) {
+	blob, err := p.Ciphertext(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := p.client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(p.KeyID),
+		CiphertextBlob: blob,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// GenerateKey implements KeyGenerator by asking AWS KMS to mint a fresh
+// AES-256 data key for envName, persisting its ciphertext via
+// StoreCiphertext and returning the plaintext to encrypt with.
+func (p *AWSKMSKeyProvider) GenerateKey(envName string) ([]byte, error) {
+	out, err := p.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.KeyID),
+		KeySpec: aws.String("AES_256"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.StoreCiphertext != nil {
+		if err := p.StoreCiphertext(envName, out.CiphertextBlob); err != nil {
+			return nil, err
+		}
+	}
+	return out.Plaintext, nil
+}
+
+// GCPKMSKeyProvider derives the per-environment data key by asking a GCP
+// Cloud KMS key to decrypt a ciphertext blob stored alongside the
+// environment.
+type GCPKMSKeyProvider struct {
+	KeyName         string // projects/*/locations/*/keyRings/*/cryptoKeys/*
+	Ciphertext      func(envName string) ([]byte, error)
+	StoreCiphertext func(envName string, ciphertext []byte) error
+
+	service *cloudkms.Service
+}
+
+// NewGCPKMSKeyProvider returns a KeyProvider backed by the given Cloud KMS
+// key. ciphertext must return the encrypted data key for an environment.
+// storeCiphertext is called by GenerateKey to persist the ciphertext minted
+// for a brand new environment, and may be nil if the provider is only ever
+// used against environments that already exist.
+func NewGCPKMSKeyProvider(ctx context.Context, keyName string, ciphertext func(envName string) ([]byte, error), storeCiphertext func(envName string, ciphertext []byte) error) (*GCPKMSKeyProvider, error) {
+	svc, err := cloudkms.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCPKMSKeyProvider{KeyName: keyName, Ciphertext: ciphertext, StoreCiphertext: storeCiphertext, service: svc}, nil
+}
+
+// Key implements KeyProvider.
+func (p *GCPKMSKeyProvider) Key(envName string) ([]byte, error) {
+	blob, err := p.Ciphertext(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &cloudkms.DecryptRequest{Ciphertext: encodeBase64(blob)}
+	resp, err := p.service.Projects.Locations.KeyRings.CryptoKeys.Decrypt(p.KeyName, req).Do()
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64(resp.Plaintext)
+}
+
+// GenerateKey implements KeyGenerator by generating a random 256-bit data
+// key locally, wrapping it with the Cloud KMS key, persisting the resulting
+// ciphertext via StoreCiphertext and returning the plaintext to encrypt
+// with. Cloud KMS keys are envelope keys with no native "generate data key"
+// call of their own, unlike AWS KMS, so the data key is minted client-side.
+func (p *GCPKMSKeyProvider) GenerateKey(envName string) ([]byte, error) {
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+
+	req := &cloudkms.EncryptRequest{Plaintext: encodeBase64(plaintext)}
+	resp, err := p.service.Projects.Locations.KeyRings.CryptoKeys.Encrypt(p.KeyName, req).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.StoreCiphertext != nil {
+		ciphertext, err := decodeBase64(resp.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.StoreCiphertext(envName, ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	return plaintext, nil
+}
+
+// VaultTransitKeyProvider derives the per-environment data key by asking
+// Vault's Transit secrets engine to decrypt a ciphertext stored alongside
+// the environment.
+type VaultTransitKeyProvider struct {
+	Mount           string // e.g. "transit"
+	KeyName         string
+	Ciphertext      func(envName string) (string, error) // vault:v1:... ciphertext
+	StoreCiphertext func(envName string, ciphertext string) error
+
+	client *vault.Client
+}
+
+// NewVaultTransitKeyProvider returns a KeyProvider backed by the given
+// Transit key. storeCiphertext is called by GenerateKey to persist the
+// ciphertext minted for a brand new environment, and may be nil if the
+// provider is only ever used against environments that already exist.
+func NewVaultTransitKeyProvider(client *vault.Client, mount, keyName string, ciphertext func(envName string) (string, error), storeCiphertext func(envName string, ciphertext string) error) *VaultTransitKeyProvider {
+	return &VaultTransitKeyProvider{Mount: mount, KeyName: keyName, Ciphertext: ciphertext, StoreCiphertext: storeCiphertext, client: client}
+}
+
+// Key implements KeyProvider.
+func (p *VaultTransitKeyProvider) Key(envName string) ([]byte, error) {
+	ciphertext, err := p.Ciphertext(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := p.client.Logical().Write(p.Mount+"/decrypt/"+p.KeyName, map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, _ := secret.Data["plaintext"].(string)
+	return decodeBase64(plaintext)
+}
+
+// GenerateKey implements KeyGenerator using Transit's datakey/plaintext
+// endpoint, which mints and wraps a fresh data key server-side in one call.
+// The ciphertext is persisted via StoreCiphertext and the plaintext
+// returned to encrypt with.
+func (p *VaultTransitKeyProvider) GenerateKey(envName string) ([]byte, error) {
+	secret, err := p.client.Logical().Write(p.Mount+"/datakey/plaintext/"+p.KeyName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, _ := secret.Data["ciphertext"].(string)
+	if p.StoreCiphertext != nil {
+		if err := p.StoreCiphertext(envName, ciphertext); err != nil {
+			return nil, err
+		}
+	}
+
+	plaintext, _ := secret.Data["plaintext"].(string)
+	return decodeBase64(plaintext)
+}
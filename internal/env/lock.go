@@ -0,0 +1,58 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// Locker is implemented by backends that can provide OS-level advisory
+// locking around a read-modify-write cycle of one of their entries. Only
+// localBackend implements it today - remote backends (S3, Vault, ...) have
+// their own atomicity guarantees and do not need it.
+type Locker interface {
+	// Lock blocks until an exclusive advisory lock on name is acquired and
+	// returns a function that releases it.
+	Lock(name string) (unlock func(), err error)
+}
+
+// Lock acquires an OS-level advisory lock (flock on Unix, LockFileEx on
+// Windows via the same cross-platform library) on the file name within the
+// backend's directory, so two rriclient processes never interleave a
+// read-modify-write cycle on the same entry.
+func (b *localBackend) Lock(name string) (func(), error) {
+	root := b.rootFor(name)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	lockPath := filepath.Join(root, "."+name+".lock")
+	l := flock.New(lockPath)
+	if err := l.Lock(); err != nil {
+		return nil, err
+	}
+	return func() { l.Unlock() }, nil
+}
+
+// withLock runs fn while holding an exclusive lock on name, if locking is
+// enabled and the configured backend supports it. Otherwise fn runs
+// unprotected, same as before locking was introduced.
+func (e *Reader) withLock(name string, fn func() error) error {
+	if !e.locking {
+		return fn()
+	}
+
+	locker, ok := e.backend.(Locker)
+	if !ok {
+		return fn()
+	}
+
+	unlock, err := locker.Lock(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
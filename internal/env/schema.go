@@ -0,0 +1,247 @@
+package env
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/sbreitf1/go-jcrypt"
+)
+
+// schemaHeaderField is the top-level JSON key every schema-registered
+// environment file carries next to its (possibly encrypted) data.
+const schemaHeaderField = "_schema"
+
+// MigrateFunc transforms an environment's raw field map from version-1 of
+// its schema to the version it is registered for.
+type MigrateFunc func(old map[string]interface{}) (map[string]interface{}, error)
+
+// SchemaError is returned by ReadEnvironment when an environment's stored
+// schema does not match what was registered for its kind, or when no
+// migration path exists from its stored version to the latest registered
+// one.
+type SchemaError struct {
+	EnvName       string
+	Kind          string
+	StoredVersion int
+	WantVersion   int
+	Reason        string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("environment %q: schema %q version %d: %s", e.EnvName, e.Kind, e.StoredVersion, e.Reason)
+}
+
+type schemaHeader struct {
+	Kind    string `json:"kind"`
+	Version int    `json:"version"`
+}
+
+type schemaVersion struct {
+	version int
+	proto   interface{}
+	migrate MigrateFunc
+}
+
+// EnvSchema is a registered chain of versions for a given environment kind.
+type EnvSchema struct {
+	kind     string
+	versions []schemaVersion
+}
+
+func (s *EnvSchema) latest() schemaVersion {
+	return s.versions[len(s.versions)-1]
+}
+
+func (s *EnvSchema) earliest() schemaVersion {
+	return s.versions[0]
+}
+
+func (s *EnvSchema) versionAfter(version int) (schemaVersion, bool) {
+	for _, v := range s.versions {
+		if v.version == version {
+			return v, true
+		}
+	}
+	return schemaVersion{}, false
+}
+
+// RegisterSchema registers version as the current definition of the
+// environment kind, with proto as a zero-value sample of the struct it
+// unmarshals into. migrate transforms the raw field map of version-1 into
+// one compatible with version, and may be nil for the first version of a
+// kind, which has nothing to migrate from. Migration steps one version
+// number at a time, so a gap in the registered versions makes reading an
+// older file fail with a SchemaError.
+//
+// Once a version is registered, createOrReadEnvironment tags every newly
+// written environment of that kind with a `_schema` header, and
+// ReadEnvironment chains migrate functions to bring older files up to date
+// on read, re-persisting the result.
+func (e *Reader) RegisterSchema(kind string, version int, proto interface{}, migrate MigrateFunc) {
+	if e.schemas == nil {
+		e.schemas = make(map[string]*EnvSchema)
+	}
+
+	s, ok := e.schemas[kind]
+	if !ok {
+		s = &EnvSchema{kind: kind}
+		e.schemas[kind] = s
+	}
+	s.versions = append(s.versions, schemaVersion{version: version, proto: proto, migrate: migrate})
+	sort.Slice(s.versions, func(i, j int) bool { return s.versions[i].version < s.versions[j].version })
+}
+
+// isSchemaOutdated reports whether the env file with the given name carries
+// a `_schema` header whose version is older than the latest one registered
+// for its kind. It never requires the decryption key, since the header is
+// never encrypted.
+func (e *Reader) isSchemaOutdated(file string) bool {
+	data, err := e.backend.Get(file)
+	if err != nil {
+		return false
+	}
+
+	header, ok := peekSchemaHeader(data)
+	if !ok {
+		return false
+	}
+
+	schema, ok := e.schemas[header.Kind]
+	if !ok || len(schema.versions) == 0 {
+		return false
+	}
+	return header.Version < schema.latest().version
+}
+
+// schemaFor returns the registered schema whose latest proto has the same
+// underlying type as env, if any.
+func (e *Reader) schemaFor(env interface{}) *EnvSchema {
+	envType := dereferencedType(env)
+	for _, s := range e.schemas {
+		if len(s.versions) == 0 {
+			continue
+		}
+		if dereferencedType(s.latest().proto) == envType {
+			return s
+		}
+	}
+	return nil
+}
+
+func dereferencedType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// wrapWithSchema adds a `_schema` header to the JSON object produced by
+// jcrypt for env, if a schema is registered for env's type.
+func wrapWithSchema(data []byte, schema *EnvSchema) ([]byte, error) {
+	if schema == nil {
+		return data, nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	obj[schemaHeaderField] = schemaHeader{Kind: schema.kind, Version: schema.latest().version}
+
+	return json.Marshal(obj)
+}
+
+// peekSchemaHeader reads the `_schema` header from data without requiring
+// the decryption key, since the header itself is never encrypted.
+func peekSchemaHeader(data []byte) (schemaHeader, bool) {
+	var wrapper struct {
+		Schema *schemaHeader `json:"_schema"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil || wrapper.Schema == nil {
+		return schemaHeader{}, false
+	}
+	return *wrapper.Schema, true
+}
+
+// migrateEnvironment chains migrate functions registered for schema from
+// header.Version up to the latest registered version, applying them to data
+// decoded into the stored version's typed proto, then unmarshals the
+// migrated result into env.
+func migrateEnvironment(envName string, data []byte, env interface{}, schema *EnvSchema, header schemaHeader, opts *jcrypt.Options) ([]byte, error) {
+	oldVersion, ok := schema.versionAfter(header.Version)
+	if !ok {
+		return nil, &SchemaError{
+			EnvName:       envName,
+			Kind:          schema.kind,
+			StoredVersion: header.Version,
+			WantVersion:   schema.latest().version,
+			Reason:        fmt.Sprintf("no registered version %d to decode from", header.Version),
+		}
+	}
+
+	// jcrypt decides which fields to decrypt by walking the proto's struct
+	// tags via reflection, so data must be decoded into a typed instance of
+	// the stored version's proto rather than a bare map - otherwise
+	// encrypted fields come out as raw ciphertext and get double-encrypted
+	// on re-marshal below.
+	typed := reflect.New(dereferencedType(oldVersion.proto)).Interface()
+	if err := jcrypt.Unmarshal(data, typed, opts); err != nil {
+		return nil, err
+	}
+
+	typedJSON, err := json.Marshal(typed)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(typedJSON, &fields); err != nil {
+		return nil, err
+	}
+
+	version := header.Version
+	for version < schema.latest().version {
+		next, ok := schema.versionAfter(version + 1)
+		if !ok {
+			return nil, &SchemaError{
+				EnvName:       envName,
+				Kind:          schema.kind,
+				StoredVersion: header.Version,
+				WantVersion:   schema.latest().version,
+				Reason:        fmt.Sprintf("no registered version %d", version+1),
+			}
+		}
+		if next.migrate == nil {
+			return nil, &SchemaError{
+				EnvName:       envName,
+				Kind:          schema.kind,
+				StoredVersion: header.Version,
+				WantVersion:   schema.latest().version,
+				Reason:        fmt.Sprintf("version %d has no migration function", next.version),
+			}
+		}
+
+		migrated, err := next.migrate(fields)
+		if err != nil {
+			return nil, fmt.Errorf("migrating %q from version %d to %d: %w", envName, version, next.version, err)
+		}
+		fields = migrated
+		version = next.version
+	}
+
+	migratedJSON, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(migratedJSON, env); err != nil {
+		return nil, err
+	}
+
+	out, err := jcrypt.Marshal(env, opts)
+	if err != nil {
+		return nil, err
+	}
+	return wrapWithSchema(out, schema)
+}
@@ -0,0 +1,108 @@
+package env
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type schemaTestEnvV3 struct {
+	Name    string `json:"name"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+func newSchemaTestReader(t *testing.T) *Reader {
+	t.Helper()
+	dir := t.TempDir()
+	e, err := NewReaderFromDir(dir)
+	if err != nil {
+		t.Fatalf("NewReaderFromDir: %v", err)
+	}
+
+	type schemaTestEnvV1 struct {
+		Name string `json:"name"`
+	}
+	type schemaTestEnvV2 struct {
+		Name string `json:"name"`
+		City string `json:"city"`
+	}
+
+	e.RegisterSchema("test", 1, schemaTestEnvV1{}, nil)
+	e.RegisterSchema("test", 2, schemaTestEnvV2{}, func(old map[string]interface{}) (map[string]interface{}, error) {
+		old["city"] = "unknown"
+		return old, nil
+	})
+	e.RegisterSchema("test", 3, schemaTestEnvV3{}, func(old map[string]interface{}) (map[string]interface{}, error) {
+		old["country"] = "world"
+		return old, nil
+	})
+	return e
+}
+
+// TestSchemaMigrationChain verifies that a file written against an old,
+// explicitly-tagged schema version is carried through every intermediate
+// version's MigrateFunc and re-persisted at the latest version.
+func TestSchemaMigrationChain(t *testing.T) {
+	e := newSchemaTestReader(t)
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"name":    "alice",
+		"_schema": schemaHeader{Kind: "test", Version: 1},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := e.backend.Put("chain.json", raw); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var env schemaTestEnvV3
+	if err := e.ReadEnvironment("chain", &env); err != nil {
+		t.Fatalf("ReadEnvironment: %v", err)
+	}
+	if env.Name != "alice" || env.City != "unknown" || env.Country != "world" {
+		t.Fatalf("got %+v, want Name=alice City=unknown Country=world", env)
+	}
+
+	data, err := e.backend.Get("chain.json")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	header, ok := peekSchemaHeader(data)
+	if !ok || header.Version != 3 {
+		t.Fatalf("peekSchemaHeader = %+v, %v, want version 3", header, ok)
+	}
+}
+
+// TestSchemaMigrationLegacyNoHeader guards against the regression where a
+// file written before RegisterSchema existed for its kind - and so carries
+// no _schema header at all - silently skipped migration entirely, leaving
+// fields added by later versions zero-valued forever with no way for a
+// later read to catch it.
+func TestSchemaMigrationLegacyNoHeader(t *testing.T) {
+	e := newSchemaTestReader(t)
+
+	raw, err := json.Marshal(map[string]interface{}{"name": "bob"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := e.backend.Put("legacy.json", raw); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var env schemaTestEnvV3
+	if err := e.ReadEnvironment("legacy", &env); err != nil {
+		t.Fatalf("ReadEnvironment: %v", err)
+	}
+	if env.Name != "bob" || env.City != "unknown" || env.Country != "world" {
+		t.Fatalf("got %+v, want Name=bob City=unknown Country=world", env)
+	}
+
+	data, err := e.backend.Get("legacy.json")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, ok := peekSchemaHeader(data); !ok {
+		t.Fatalf("legacy file was not tagged with a _schema header after migration")
+	}
+}
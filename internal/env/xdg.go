@@ -0,0 +1,200 @@
+package env
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/sbreitf1/go-console"
+)
+
+// envDirOverrideVar, when set, fully overrides the computed configuration
+// directory; see NewReaderFromDir.
+const envDirOverrideVar = "RRICLIENT_ENV_DIR"
+
+// NewReaderFromDir returns a new environment reader that stores both
+// environment files and the env-order index under dir, bypassing XDG
+// resolution entirely. This is what RRICLIENT_ENV_DIR uses under the hood,
+// and lets users point at a repo-local directory for project-scoped
+// environments.
+func NewReaderFromDir(dir string, opts ...ReaderOption) (*Reader, error) {
+	e := &Reader{dir: dir, locking: true}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.backend == nil {
+		e.backend = newLocalBackend(dir)
+	}
+	return e, nil
+}
+
+// NewReader returns a new environment reader for the directory homeDirName.
+// By default it resolves an XDG-compliant directory for homeDirName -
+// $XDG_CONFIG_HOME (or %AppData% on Windows) for environment files and
+// $XDG_DATA_HOME (or %LocalAppData% on Windows) for the env-order index -
+// migrating any pre-existing ~/homeDirName contents into the new location
+// on first run. Set RRICLIENT_ENV_DIR to fully override the computed
+// directory, or pass WithBackend to use a different storage backend such as
+// S3 or Vault, and WithKeyProvider to source the encryption key from a
+// cloud KMS instead of the KeySource field.
+func NewReader(homeDirName string, opts ...ReaderOption) (*Reader, error) {
+	if override := os.Getenv(envDirOverrideVar); override != "" {
+		return NewReaderFromDir(override, opts...)
+	}
+
+	configDir, err := xdgConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dataDir, err := xdgDataDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(configDir, homeDirName)
+	dataSubDir := filepath.Join(dataDir, homeDirName)
+
+	if legacyDir, err := getConfigDir(); err == nil {
+		legacyHomeDir := filepath.Join(legacyDir, homeDirName)
+		migrateLegacyHomeDir(legacyHomeDir, dir)
+		migrateLegacyEnvOrder(legacyHomeDir, dataSubDir)
+	}
+
+	e := &Reader{dir: dir, locking: true}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.backend == nil {
+		e.backend = newLocalBackendXDG(dir, dataSubDir)
+	}
+
+	return e, nil
+}
+
+// xdgConfigDir returns the directory environment files should live under:
+// %AppData% on Windows, otherwise $XDG_CONFIG_HOME or ~/.config.
+func xdgConfigDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("AppData"); dir != "" {
+			return dir, nil
+		}
+		return getConfigDir()
+	}
+
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config"), nil
+}
+
+// xdgDataDir returns the directory the env-order index should live under:
+// %LocalAppData% on Windows, otherwise $XDG_DATA_HOME or ~/.local/share.
+func xdgDataDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return getConfigDir()
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// migrateLegacyHomeDir makes an environment directory from its pre-XDG
+// location directly under the user's home directory available at newDir,
+// by symlinking it or, if that fails, copying its files; the legacy
+// directory itself is left in place. This way nobody
+// loses existing environments when upgrading. It is a one-shot operation:
+// if newDir already exists, or legacyDir does not, it does nothing.
+func migrateLegacyHomeDir(legacyDir, newDir string) {
+	if legacyDir == newDir {
+		return
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		return
+	}
+	if fi, err := os.Stat(legacyDir); err != nil || !fi.IsDir() {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), os.ModePerm); err != nil {
+		console.Printlnf("WARNING: failed to migrate %q to %q: %s", legacyDir, newDir, err.Error())
+		return
+	}
+
+	if err := os.Symlink(legacyDir, newDir); err == nil {
+		return
+	}
+
+	// Symlinks can fail across filesystems/devices or on platforms without
+	// symlink support; fall back to a plain copy of the directory contents.
+	if err := copyDir(legacyDir, newDir); err != nil {
+		console.Printlnf("WARNING: failed to migrate %q to %q: %s", legacyDir, newDir, err.Error())
+	}
+}
+
+// migrateLegacyEnvOrder copies the env-order index out of the pre-XDG
+// combined directory into dataDir. Before the config/data split, env-order
+// lived alongside the environment files themselves, so migrateLegacyHomeDir
+// migrating legacyDir into the new config dir does not also put env-order
+// where newLocalBackendXDG now expects to find it - without this, an
+// upgrading user's MRU order, tags, groups and pins would be silently
+// dropped even though their environment files migrated fine. It is a
+// one-shot operation: if the destination already exists, or the legacy file
+// does not, it does nothing.
+func migrateLegacyEnvOrder(legacyDir, dataDir string) {
+	dst := filepath.Join(dataDir, envOrderFileName)
+	if _, err := os.Stat(dst); err == nil {
+		return
+	}
+
+	src := filepath.Join(legacyDir, envOrderFileName)
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		console.Printlnf("WARNING: failed to migrate %q to %q: %s", src, dst, err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(dst, data, os.ModePerm); err != nil {
+		console.Printlnf("WARNING: failed to migrate %q to %q: %s", src, dst, err.Error())
+	}
+}
+
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dst, entry.Name()), data, entry.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}